@@ -5,6 +5,8 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/leylandski/go-bc5"
@@ -13,19 +15,31 @@ import (
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const helpText = `BC5 compression/decompression tool - v1.0 - usage:
-	-id, --inputdir			Specifies an input directory. All image files matching supported file type extensions will be converted.
+	-id, --inputdir			Specifies an input directory. All files recognised (by magic bytes, not extension) as a supported input format will be converted.
 	-i, --input				Specifies an input file. Only this file will be converted.
 	-o, --output			Specifies an output directory to write to. If none is specified the working directory is used.
 	-c, --compress			Sets the mode to compress the input file into a .bc5 output file. This is the default if neither mode flag is specified.
 	-d, --decompress		Sets the mode to decompress the input file into the output directory in the format specified by -of.
 	-of, --outformat		Specifies the output format for decompression. Currently only "jpg", "gif", and "png" are supported.
+	-f, --format			Specifies the block-compression container format to use. Currently only "bc5" is supported. Defaults to "bc5".
+	-j, --jobs				Specifies the number of files to convert concurrently. Defaults to the number of logical CPUs.
+	--jpeg-quality			Specifies the JPEG output quality, from 1-100. Defaults to 90. Only used when -of is jpg.
+	--png-level				Specifies the PNG output compression level. Acceptable values are "default", "speed", "best", and "none". Defaults to "default".
+	--gif-colors			Specifies the number of palette entries in GIF output, from 1-256. Defaults to 256. Only used when -of is gif.
+	--wrap					When compressing, wraps the container stream with a general-purpose compressor. Acceptable values are "none", "gzip", and "zstd". Defaults to "none". When decompressing, gzip/zstd-wrapped input is detected and unwrapped automatically, whatever this flag says.
+	-og, --gooutput			When compressing, emit a .go source file embedding the compressed data instead of a raw container file.
+	--gopkg					Package name to use in the generated Go source. Defaults to "main". Only used with -og/--gooutput.
+	--gosym					Symbol name for the generated accessor function. Defaults to a sanitised form of the input filename. Only used with -og/--gooutput.
 	-b, --blue				Specified the how the blue component is determined during decompression. Acceptable values are:
 							0		- Sets every output pixel's blue component to 0.
 							1		- Sets every output pixel's blue component to 255.
@@ -85,6 +99,22 @@ func formatExt(f OutputFormat) string {
 	}
 }
 
+// Parse a string into a png.Encoder compression level.
+func parsePNGLevel(s string) (png.CompressionLevel, error) {
+	switch strings.ToLower(s) {
+	case "default":
+		return png.DefaultCompression, nil
+	case "speed":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	default:
+		return png.DefaultCompression, fmt.Errorf("unknown PNG compression level: %s", s)
+	}
+}
+
 // Return the blue computation mode parsed from a string.
 func parseBlueMode(bm string) bc5.BlueMode {
 	switch bm {
@@ -100,12 +130,22 @@ func parseBlueMode(bm string) bc5.BlueMode {
 }
 
 var (
-	mode     Mode         //Program mode
-	isDir    bool         //Operate on a dir
-	target   string       //Input target
-	outPath  string       //Output path
-	outFmt   OutputFormat //Output format (if decompressing)
-	blueMode bc5.BlueMode //Blue computation mode
+	mode        Mode         //Program mode
+	isDir       bool         //Operate on a dir
+	target      string       //Input target
+	outPath     string       //Output path
+	outFmt      OutputFormat //Output format (if decompressing)
+	blueMode    bc5.BlueMode //Blue computation mode
+	formatName  = "bc5"      //Name of the registered CompressionFormat to use
+	format      CompressionFormat
+	goOutput    bool                     //Emit a .go source file instead of a raw container file when compressing
+	goPkg       string                   //Package name for generated Go source
+	goSym       string                   //Symbol name for generated Go source
+	jobs        = runtime.NumCPU()       //Number of files to convert concurrently
+	jpegQuality = 90                     //JPEG output quality (1-100)
+	pngLevel    = png.DefaultCompression //PNG output compression level
+	gifColors   = 256                    //Number of palette entries in GIF output
+	wrapMode    WrapMode                 //General-purpose compressor wrapping the container stream
 )
 
 // Main entry point
@@ -128,6 +168,11 @@ func main() {
 			continue
 		}
 
+		if arg == "-og" || arg == "--gooutput" {
+			goOutput = true
+			continue
+		}
+
 		if argName == "" {
 			argName = arg
 			continue
@@ -143,6 +188,57 @@ func main() {
 			outPath = arg
 		case "-of", "--outformat":
 			outFmt = parseFormat(arg)
+		case "-f", "--format":
+			formatName = arg
+		case "-j", "--jobs":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 {
+				fmt.Printf("Invalid job count: %s.\n%s", arg, helpText)
+				os.Exit(1)
+			}
+			jobs = n
+		case "--gopkg":
+			p := legalizeIdent(arg)
+			if !isValidIdent(p) {
+				fmt.Printf("Invalid --gopkg value: %s.\n%s", arg, helpText)
+				os.Exit(1)
+			}
+			goPkg = p
+		case "--gosym":
+			s := legalizeIdent(arg)
+			if !isValidIdent(s) {
+				fmt.Printf("Invalid --gosym value: %s.\n%s", arg, helpText)
+				os.Exit(1)
+			}
+			goSym = s
+		case "--jpeg-quality":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > 100 {
+				fmt.Printf("Invalid JPEG quality: %s.\n%s", arg, helpText)
+				os.Exit(1)
+			}
+			jpegQuality = n
+		case "--png-level":
+			lvl, err := parsePNGLevel(arg)
+			if err != nil {
+				fmt.Printf("%s.\n%s", err.Error(), helpText)
+				os.Exit(1)
+			}
+			pngLevel = lvl
+		case "--gif-colors":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > 256 {
+				fmt.Printf("Invalid GIF color count: %s.\n%s", arg, helpText)
+				os.Exit(1)
+			}
+			gifColors = n
+		case "--wrap":
+			wm, err := parseWrapMode(arg)
+			if err != nil {
+				fmt.Printf("%s.\n%s", err.Error(), helpText)
+				os.Exit(1)
+			}
+			wrapMode = wm
 		case "-b", "--blue":
 			blueMode = parseBlueMode(arg)
 		default:
@@ -155,6 +251,12 @@ func main() {
 		fmt.Printf("Unsupported output format. Supported formats include PNG, GIF, and JPG.\n%s", helpText)
 		os.Exit(1)
 	}
+	var ok bool
+	format, ok = FormatByName(formatName)
+	if !ok {
+		fmt.Printf("Unsupported compression format: %s.\n%s", formatName, helpText)
+		os.Exit(1)
+	}
 	if outPath == "" {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -166,129 +268,214 @@ func main() {
 	//Make a list of files to convert
 	files := make([]string, 0)
 	if isDir {
-		//Walk through the filepath and get any files we can convert
+		//Walk through the filepath and get any files we can convert, identifying candidates
+		//by magic bytes rather than extension so renamed/extensionless files are still picked up.
 		err := filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			in, sniffErr := sniffFormat(path)
+			if sniffErr != nil {
+				return nil
+			}
 			if mode == Compress {
-				if strings.HasSuffix(path, ".jpg") || strings.HasSuffix(path, ".png") || strings.HasSuffix(path, ".gif") {
+				if in == InPNG || in == InJPEG || in == InGIF {
 					files = append(files, path)
 				}
 			} else {
-				if strings.HasSuffix(path, ".bc5") {
+				if in == InContainer {
 					files = append(files, path)
 				}
 			}
-			return err
+			return nil
 		})
 		if err != nil {
 			panic(err)
 		}
 	} else {
+		in, err := sniffFormat(target)
+		if err != nil {
+			fmt.Printf("Unable to identify input file %s: %s.\n", target, err.Error())
+			os.Exit(1)
+		}
+		if mode == Compress && !(in == InPNG || in == InJPEG || in == InGIF) {
+			fmt.Printf("%s does not look like a supported image file.\n", target)
+			os.Exit(1)
+		}
+		if mode == Decompress && in != InContainer {
+			fmt.Printf("%s does not look like a %s container file.\n", target, format.Name())
+			os.Exit(1)
+		}
 		files = append(files, target)
 	}
 	fmt.Printf("Converting %d files...\n", len(files))
 
-	//Begin compression/decompression
+	//Begin compression/decompression, fanning the file list out across a bounded worker pool
 	start := time.Now()
+	var results []jobResult
 	if mode == Compress {
-		for _, filename := range files {
-			compressFile(filename)
-		}
+		results = runPool(files, jobs, compressFile)
 	} else {
-		for _, filename := range files {
-			decompressFile(filename)
-		}
+		results = runPool(files, jobs, decompressFile)
 	}
 	end := time.Now()
 	timeTaken := end.Sub(start)
 
-	fmt.Printf("Done! Converted %d files in %f seconds (%f files/sec).\n", len(files), timeTaken.Seconds(), float64(len(files))/timeTaken.Seconds())
+	failures := 0
+	for _, r := range results {
+		if r.err != nil {
+			failures++
+			fmt.Printf("FAILED %s: %s\n", r.filename, r.err.Error())
+		}
+	}
+
+	fmt.Printf("Done! Converted %d files (%d failed) in %f seconds (%f files/sec).\n", len(files), failures, timeTaken.Seconds(), float64(len(files))/timeTaken.Seconds())
+	if failures > 0 {
+		os.Exit(1)
+	}
 }
 
-// Compress the given file using the current program settings
-func compressFile(filename string) {
+// Compress the given file using the current program settings, reporting progress via logCh.
+func compressFile(filename string, logCh chan<- string) error {
 	f, err := os.Open(filename)
 	if err != nil {
-		fmt.Printf("Unable to open %s: %s\n", filename, err.Error())
-		os.Exit(1)
+		return fmt.Errorf("unable to open %s: %w", filename, err)
 	}
 	defer f.Close()
 
 	//Decode image to generic
 	img, _, err := image.Decode(f)
 	if err != nil {
-		fmt.Printf("Error reading file: %s\n", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error reading %s: %w", filename, err)
 	}
 
 	//Redraw as RGBA
 	imgRgba := image.NewRGBA(img.Bounds())
 	draw.Draw(imgRgba, imgRgba.Bounds(), img, img.Bounds().Min, draw.Src)
 
-	//Compress the RGBA data to BC5
-	fmt.Printf("Compressing %s... ", filename)
-	compressed, err := bc5.NewBC5FromRGBA(imgRgba)
+	//Compress the RGBA data using the selected format
+	logCh <- fmt.Sprintf("Compressing %s...\n", filename)
+	compressed, err := format.CompressRGBA(imgRgba)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error compressing %s: %w", filename, err)
 	}
-	fmt.Print("done.\n")
 
-	//Save the BC5 output
 	fnameParts := strings.Split(strings.Replace(filename, "\\", "/", -1), "/")
-	outFile, err := os.Create(strings.TrimSuffix(outPath, string(os.PathSeparator)) + string(os.PathSeparator) + fnameParts[len(fnameParts)-1] + ".bc5")
+	baseName := fnameParts[len(fnameParts)-1]
+
+	if goOutput {
+		//Emit the compressed data as embeddable Go source instead of a raw container file.
+		var raw bytes.Buffer
+		if err := format.Encode(compressed, &raw); err != nil {
+			return fmt.Errorf("error encoding %s: %w", filename, err)
+		}
+
+		pkg := goPkg
+		if pkg == "" {
+			pkg = "main"
+		}
+		sym := goSym
+		if sym == "" {
+			sym = sanitizeIdent(strings.TrimSuffix(baseName, filepath.Ext(baseName)))
+		}
+
+		src, err := format.GoSource(compressed, raw.Bytes(), pkg, sym)
+		if err != nil {
+			return fmt.Errorf("error generating Go source for %s: %w", filename, err)
+		}
+
+		outFile, err := os.Create(strings.TrimSuffix(outPath, string(os.PathSeparator)) + string(os.PathSeparator) + baseName + ".go")
+		if err != nil {
+			return fmt.Errorf("error creating output file for %s: %w", filename, err)
+		}
+		defer outFile.Close()
+
+		if _, err := outFile.Write(src); err != nil {
+			return fmt.Errorf("error writing output file for %s: %w", filename, err)
+		}
+		logCh <- fmt.Sprintf("Compressed %s.\n", filename)
+		return nil
+	}
+
+	//Save the compressed output, optionally wrapped in a general-purpose compressor
+	outName := strings.TrimSuffix(outPath, string(os.PathSeparator)) + string(os.PathSeparator) + baseName + "." + format.Extension() + wrapMode.Extension()
+	outFile, err := os.Create(outName)
 	if err != nil {
-		fmt.Printf("Error creating output file: %s\n", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error creating output file for %s: %w", filename, err)
 	}
 	defer outFile.Close()
 
-	err = bc5.Encode(compressed, outFile)
+	w, closer, err := wrapMode.wrapWriter(outFile)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error setting up wrapper for %s: %w", filename, err)
+	}
+	if err := format.Encode(compressed, w); err != nil {
+		return fmt.Errorf("error encoding %s: %w", filename, err)
 	}
+	if closer != nil {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("error finishing compressed output for %s: %w", filename, err)
+		}
+	}
+	logCh <- fmt.Sprintf("Compressed %s.\n", filename)
+	return nil
 }
 
-// Decompress the given file using the current program settings
-func decompressFile(filename string) {
+// Decompress the given file using the current program settings, reporting progress via logCh.
+func decompressFile(filename string, logCh chan<- string) error {
 	f, err := os.Open(filename)
 	if err != nil {
-		fmt.Printf("Unable to open %s: %s\n", filename, err.Error())
-		os.Exit(1)
+		return fmt.Errorf("unable to open %s: %w", filename, err)
 	}
 	defer f.Close()
 
-	//Decode the BC5 data into a struct
-	img, err := bc5.Decode(f)
+	//Transparently unwrap a gzip/zstd-wrapped container before inspecting its contents.
+	r, unwrapCloser, err := unwrapReader(bufio.NewReader(f))
 	if err != nil {
-		fmt.Printf("Error decoding BC5 data: %s.\n", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error unwrapping %s: %w", filename, err)
+	}
+	if unwrapCloser != nil {
+		defer unwrapCloser.Close()
+	}
+
+	//Check the magic bytes match the selected format before handing off to Decode, so a
+	//mismatched container produces a clear error instead of a confusing decode failure.
+	magic := make([]byte, len(format.MagicBytes()))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, format.MagicBytes()) {
+		return fmt.Errorf("%s is not a valid %s container file", filename, format.Name())
+	}
+
+	//Decode the compressed data into a Blob
+	blob, err := format.Decode(io.MultiReader(bytes.NewReader(magic), r))
+	if err != nil {
+		return fmt.Errorf("error decoding %s data for %s: %w", format.Name(), filename, err)
 	}
 
 	//Decompress using the current settings
-	fmt.Printf("Decompressing %s... ", filename)
-	img.BlueMode = blueMode
-	decomp := img.Decompress()
-	fmt.Printf("done.\n")
+	logCh <- fmt.Sprintf("Decompressing %s...\n", filename)
+	decomp := format.DecompressToImage(blob, DecompressOptions{BlueMode: blueMode})
 
 	//Write the decompressed contents to the output file
 	fnameParts := strings.Split(strings.Replace(filename, "\\", "/", -1), "/")
 	outFile, err := os.Create(strings.TrimSuffix(outPath, string(os.PathSeparator)) + string(os.PathSeparator) + fnameParts[len(fnameParts)-1] + "." + formatExt(outFmt))
 	if err != nil {
-		fmt.Printf("Error creating output file: %s.\n", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error creating output file for %s: %w", filename, err)
 	}
 	defer outFile.Close()
 
 	switch outFmt {
 	case PNG:
-		err = png.Encode(outFile, decomp)
+		err = (&png.Encoder{CompressionLevel: pngLevel}).Encode(outFile, decomp)
 	case JPG:
-		err = jpeg.Encode(outFile, decomp, nil)
+		err = jpeg.Encode(outFile, decomp, &jpeg.Options{Quality: jpegQuality})
 	case GIF:
-		err = gif.Encode(outFile, decomp, nil)
+		err = gif.Encode(outFile, decomp, &gif.Options{NumColors: gifColors})
 	default:
 		err = errors.New("unsupported output format")
 	}
 	if err != nil {
-		fmt.Printf("Error creating output file: %s.\n", err.Error())
+		return fmt.Errorf("error writing output file for %s: %w", filename, err)
 	}
+	logCh <- fmt.Sprintf("Decompressed %s.\n", filename)
+	return nil
 }
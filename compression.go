@@ -0,0 +1,70 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/leylandski/go-bc5"
+	"image"
+	"io"
+	"strings"
+)
+
+// Blob is an opaque compressed-format payload produced by a CompressionFormat's
+// CompressRGBA or Decode methods, and consumed by its Encode and DecompressToImage methods.
+type Blob interface{}
+
+// DecompressOptions carries the settings needed to turn a Blob back into an image.Image.
+// Formats that have no use for a given setting are free to ignore it.
+type DecompressOptions struct {
+	BlueMode bc5.BlueMode //How to reconstruct the blue channel, for two-channel formats such as BC5.
+}
+
+// CompressionFormat describes a block-compression texture format that the converter knows
+// how to read and write. Adding a new format (BC1, BC3, BC4, BC7, ...) means implementing
+// this interface and calling Register on it; the CLI and directory walker dispatch off the
+// registry rather than switching on hard-coded formats.
+type CompressionFormat interface {
+	//Name is the short identifier used by -f/--format, e.g. "bc5".
+	Name() string
+	//Extension is the file extension (without the dot) this format's container files use.
+	Extension() string
+	//MagicBytes is the byte sequence a container file starts with.
+	MagicBytes() []byte
+	//CompressRGBA compresses decoded image data into this format's Blob representation.
+	CompressRGBA(img *image.RGBA) (Blob, error)
+	//Decode reads a Blob from its on-disk container representation.
+	Decode(r io.Reader) (Blob, error)
+	//Encode writes a Blob to its on-disk container representation.
+	Encode(b Blob, w io.Writer) error
+	//DecompressToImage reconstructs a displayable image.Image from a Blob.
+	DecompressToImage(b Blob, opts DecompressOptions) image.Image
+	//GoSource renders raw (this format's encoded container bytes for b) as a standalone
+	//.go file in package pkg, exposing a func sym() that reconstructs b at runtime.
+	GoSource(b Blob, raw []byte, pkg, sym string) ([]byte, error)
+}
+
+var formatsByName = map[string]CompressionFormat{}
+
+// Register adds a CompressionFormat to the registry, keyed by its name.
+func Register(f CompressionFormat) {
+	formatsByName[f.Name()] = f
+}
+
+// FormatByName looks up a registered format by its -f/--format name.
+func FormatByName(name string) (CompressionFormat, bool) {
+	f, ok := formatsByName[strings.ToLower(name)]
+	return f, ok
+}
+
+// FormatByMagic looks up a registered format by sniffing the magic bytes at the start of buf.
+func FormatByMagic(buf []byte) (CompressionFormat, bool) {
+	for _, f := range formatsByName {
+		magic := f.MagicBytes()
+		if len(buf) >= len(magic) && bytes.Equal(buf[:len(magic)], magic) {
+			return f, true
+		}
+	}
+	return nil, false
+}
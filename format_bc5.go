@@ -0,0 +1,76 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/leylandski/go-bc5"
+	"image"
+	"io"
+)
+
+// bc5Format implements CompressionFormat for the BC5 two-channel block compression format.
+type bc5Format struct{}
+
+func init() {
+	Register(bc5Format{})
+}
+
+func (bc5Format) Name() string {
+	return "bc5"
+}
+
+func (bc5Format) Extension() string {
+	return "bc5"
+}
+
+func (bc5Format) MagicBytes() []byte {
+	return []byte("BC5 ")
+}
+
+func (bc5Format) CompressRGBA(img *image.RGBA) (Blob, error) {
+	return bc5.NewBC5FromRGBA(img)
+}
+
+func (bc5Format) Decode(r io.Reader) (Blob, error) {
+	return bc5.Decode(r)
+}
+
+func (bc5Format) Encode(b Blob, w io.Writer) error {
+	return bc5.Encode(b.(*bc5.BC5), w)
+}
+
+func (bc5Format) DecompressToImage(b Blob, opts DecompressOptions) image.Image {
+	img := b.(*bc5.BC5)
+	img.BlueMode = opts.BlueMode
+	return img.Decompress()
+}
+
+// GoSource emits a standalone .go file embedding raw (the encoded BC5 container for b) as
+// a []byte literal, plus a func sym() that decodes it back into a usable *bc5.BC5.
+func (bc5Format) GoSource(b Blob, raw []byte, pkg, sym string) ([]byte, error) {
+	img := b.(*bc5.BC5)
+	size := img.Rect.Size()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by bc5-converter. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import (\n\t\"bytes\"\n\n\t\"github.com/leylandski/go-bc5\"\n)\n\n")
+	fmt.Fprintf(&buf, "const (\n\t%sWidth  = %d\n\t%sHeight = %d\n)\n\n", sym, size.X, sym, size.Y)
+	fmt.Fprintf(&buf, "var %sData = []byte{", sym)
+	for i, by := range raw {
+		if i%12 == 0 {
+			buf.WriteString("\n\t")
+		}
+		fmt.Fprintf(&buf, "0x%02x, ", by)
+	}
+	buf.WriteString("\n}\n\n")
+	fmt.Fprintf(&buf, "// %s reconstructs the embedded BC5 data as a *bc5.BC5, ready to call Decompress().\n", sym)
+	fmt.Fprintf(&buf, "func %s() *bc5.BC5 {\n", sym)
+	fmt.Fprintf(&buf, "\tv, err := bc5.Decode(bytes.NewReader(%sData))\n", sym)
+	fmt.Fprintf(&buf, "\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	fmt.Fprintf(&buf, "\treturn v\n}\n")
+	return buf.Bytes(), nil
+}
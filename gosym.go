@@ -0,0 +1,47 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/token"
+	"regexp"
+)
+
+var (
+	invalidIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+	validIdent        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// legalizeIdent replaces characters that can't appear in a Go identifier with "_", guarantees the
+// result doesn't start with a digit, and escapes it if it collides with a reserved keyword.
+// The result is always a legal (if not necessarily exported) Go identifier.
+func legalizeIdent(s string) string {
+	s = invalidIdentChars.ReplaceAllString(s, "_")
+	if s == "" {
+		s = "_"
+	}
+	if s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+	if token.IsKeyword(s) {
+		s += "_"
+	}
+	return s
+}
+
+// isValidIdent reports whether s is already a legal, non-keyword Go identifier.
+func isValidIdent(s string) bool {
+	return validIdent.MatchString(s) && !token.IsKeyword(s)
+}
+
+// sanitizeIdent turns an arbitrary string (typically a filename) into a valid Go identifier,
+// suitable for use as a default --gosym value. A leading lowercase ASCII letter is
+// upper-cased so the result is exported; a result starting with "_" stays unexported.
+func sanitizeIdent(s string) string {
+	s = legalizeIdent(s)
+	if s[0] >= 'a' && s[0] <= 'z' {
+		s = string(s[0]-'a'+'A') + s[1:]
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// jobResult records the outcome of converting a single file.
+type jobResult struct {
+	filename string
+	err      error
+}
+
+// convertFunc converts a single file, sending progress lines to logCh rather than writing
+// to stdout directly so output from concurrent workers doesn't interleave.
+type convertFunc func(filename string, logCh chan<- string) error
+
+// runPool dispatches files across a bounded pool of jobs goroutines, each pulling from a
+// shared feed channel, and returns one jobResult per file. A failure on one file is
+// recorded rather than aborting the run. Progress lines from every worker are funnelled
+// through a single logging goroutine so output stays deterministic.
+func runPool(files []string, jobs int, convert convertFunc) []jobResult {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	feed := make(chan string)
+	results := make(chan jobResult)
+	logCh := make(chan string, len(files))
+	logDone := make(chan struct{})
+
+	go func() {
+		for msg := range logCh {
+			fmt.Print(msg)
+		}
+		close(logDone)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range feed {
+				results <- jobResult{filename: filename, err: convert(filename, logCh)}
+			}
+		}()
+	}
+
+	go func() {
+		for _, filename := range files {
+			feed <- filename
+		}
+		close(feed)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	all := make([]jobResult, 0, len(files))
+	for r := range results {
+		all = append(all, r)
+	}
+
+	close(logCh)
+	<-logDone
+
+	return all
+}
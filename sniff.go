@@ -0,0 +1,63 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// InFormat identifies the format of a file sniffed by sniffFormat.
+type InFormat int
+
+const (
+	InUnknown InFormat = iota
+	InPNG
+	InJPEG
+	InGIF
+	InContainer //Matches a registered CompressionFormat's magic bytes.
+)
+
+var (
+	pngMagic  = []byte{0x89, 0x50, 0x4E, 0x47}
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+	gifMagic  = []byte("GIF8")
+)
+
+// sniffFormat peeks at the first few bytes of the file at path and identifies its format
+// by magic bytes rather than its file extension, so a renamed or extensionless file is
+// still recognised.
+func sniffFormat(path string) (InFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return InUnknown, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return InUnknown, err
+	}
+	buf = buf[:n]
+
+	switch {
+	case bytes.HasPrefix(buf, pngMagic):
+		return InPNG, nil
+	case bytes.HasPrefix(buf, jpegMagic):
+		return InJPEG, nil
+	case bytes.HasPrefix(buf, gifMagic):
+		return InGIF, nil
+	case bytes.HasPrefix(buf, gzipMagic), bytes.HasPrefix(buf, zstdMagic):
+		//A gzip/zstd-wrapped container file (see WrapMode) - not an image, but still a
+		//candidate for decompression.
+		return InContainer, nil
+	}
+	if _, ok := FormatByMagic(buf); ok {
+		return InContainer, nil
+	}
+	return InUnknown, errors.New("unrecognised file format")
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+// WrapMode identifies how the raw container stream written by a CompressionFormat's Encode
+// is wrapped by a general-purpose compressor, to better exploit entropy the block format
+// itself leaves on the table.
+type WrapMode int
+
+const (
+	WrapNone WrapMode = iota
+	WrapGzip
+	WrapZstd
+)
+
+var (
+	gzipMagic = []byte{0x1F, 0x8B}
+	zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// parseWrapMode parses a --wrap flag value into a WrapMode.
+func parseWrapMode(s string) (WrapMode, error) {
+	switch s {
+	case "", "none":
+		return WrapNone, nil
+	case "gzip":
+		return WrapGzip, nil
+	case "zstd":
+		return WrapZstd, nil
+	default:
+		return WrapNone, fmt.Errorf("unknown wrap mode: %s", s)
+	}
+}
+
+// Extension returns the filename suffix this WrapMode appends after the container extension,
+// e.g. "foo.png.bc5" becomes "foo.png.bc5.zst" under WrapZstd.
+func (m WrapMode) Extension() string {
+	switch m {
+	case WrapGzip:
+		return ".gz"
+	case WrapZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// wrapWriter wraps w in the compressor for this WrapMode, if any. When the returned
+// io.Closer is non-nil the caller must Close it (flushing the compressor) before closing
+// the underlying writer.
+func (m WrapMode) wrapWriter(w io.Writer) (io.Writer, io.Closer, error) {
+	switch m {
+	case WrapGzip:
+		gz := gzip.NewWriter(w)
+		return gz, gz, nil
+	case WrapZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zw, zw, nil
+	default:
+		return w, nil, nil
+	}
+}
+
+// unwrapReader peeks the first few bytes of r to detect a gzip or zstd wrapper and, if one
+// is found, returns a reader that transparently decompresses the underlying stream. When
+// the returned io.Closer is non-nil the caller must Close it once done reading, to release
+// the decompressor's buffers/goroutines.
+func unwrapReader(r *bufio.Reader) (io.Reader, io.Closer, error) {
+	peek, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(peek, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, gz, nil
+	case bytes.HasPrefix(peek, zstdMagic):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		rc := zr.IOReadCloser()
+		return rc, rc, nil
+	default:
+		return r, nil, nil
+	}
+}